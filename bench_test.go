@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"testing"
+)
+
+// benchDifficulties ProofOfWorkのベンチマークで試すDifficulty(ターゲットのビット長)
+var benchDifficulties = []int{8, 12, 16}
+
+func BenchmarkProofOfWorkSequential(b *testing.B) {
+	for _, difficulty := range benchDifficulties {
+		difficulty := difficulty
+		b.Run("difficulty="+strconv.Itoa(difficulty), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				blockchain.proofOfWorkSequential(context.Background(), 100, "bench", difficulty)
+			}
+		})
+	}
+}
+
+func BenchmarkProofOfWorkParallel(b *testing.B) {
+	for _, difficulty := range benchDifficulties {
+		difficulty := difficulty
+		b.Run("difficulty="+strconv.Itoa(difficulty), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				blockchain.ProofOfWork(context.Background(), 100, "bench", difficulty)
+			}
+		})
+	}
+}
@@ -1,40 +1,64 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"flag"
+	"math/big"
 	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/labstack/echo"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/soichiro0311/BlockChain/p2p"
+	"github.com/soichiro0311/BlockChain/storage"
+	"github.com/soichiro0311/BlockChain/wallet"
 )
 
+// MiningReward 採掘報酬として新しく発行されるコインの量
+const MiningReward = 1
+
+// InitialDifficulty チェーン開始時点のDifficulty(ターゲットのビット長)
+const InitialDifficulty = 20
+
+// RetargetInterval 何ブロックごとにDifficultyを再計算するか
+const RetargetInterval = 10
+
+// TargetBlockTimeSeconds 1ブロックあたりに期待する採掘時間(秒)
+const TargetBlockTimeSeconds = 10
+
+// maxRetargetFactor Difficultyが1回の再計算で変動できる最大倍率(上下とも)
+const maxRetargetFactor = 4
+
 /*
 BlockChain ブロックチェーン
 */
 type BlockChain struct {
 	// このブロックチェーンに含まれるブロックの配列
 	Chain []Block
-	// このブロックチェーン上の現在のトランザクション
-	CurrentTransaction Transaction
-	// このブロックチェーンに接続されている端末の配列
-	Nodes []string
-}
-
-/*
-Transaction トランザクション
-*/
-type Transaction struct {
-	//このトランザクションの送信者
-	Sender string
-	//このトランザクションの受信者
-	Recipient string
-	//このトランザクションの取引数量
-	Amount int
+	// このブロックチェーン上でまだブロックに取り込まれていないトランザクションのmempool
+	CurrentTransactions []Transaction
+	// ブロックを永続化するためのDB
+	db *storage.DB
+	// トランザクションIDごとの未使用トランザクション出力(UTXO)のキャッシュ
+	utxoSet UTXOSet
+	// 採掘中のProofOfWorkを中断するためのキャンセル関数。採掘していない間はnil
+	miningCancel context.CancelFunc
+	// Chain・CurrentTransactions・utxoSetへの同時アクセスを防ぐロック
+	// Mine・NewTransactionPost・resolveConflicts等が複数のHTTPリクエストから並行に
+	// 呼ばれても安全なように、書き込みはLock、読み取りはRLockで保護する
+	mu sync.RWMutex
 }
 
 /*
@@ -45,8 +69,12 @@ type Block struct {
 	Index int `json:"index"`
 	// ブロック生成時のタイムスタンプ
 	Timestamp int64 `json:"timestamp"`
-	// このブロックに含まれるトランザクション
-	Transactions Transaction `json:"transactions"`
+	// このブロックに含まれるトランザクションの配列
+	Transactions []Transaction `json:"transactions"`
+	// Transactionsのトランザクション ID を葉にしたマークル木のルートハッシュ(16進文字列)
+	MerkleRoot string `json:"merkle_root"`
+	// このブロックを採掘した時点のDifficulty(ターゲットのビット長)
+	Difficulty int `json:"difficulty"`
 	// このブロックに含まれるプルーフ
 	Proof int `json:"proof"`
 	// このブロックの一つ前のブロックのハッシュ値
@@ -65,57 +93,304 @@ type FullChain struct {
 
 var blockchain BlockChain
 
-func (BlockChain) init() {
-	// ジェネシスブロックを作る
-	blockchain = BlockChain{}
-	blockchain.NewBlock("1", 100)
+/*
+init ブロックチェーンを初期化する
+ :param dataDir: <string> ブロックの永続化に使うDBファイルを置くディレクトリ
+ DBの"blocks"バケットが空であればジェネシスブロックを作り、
+ 既にブロックがあればtipからPreviousHashを辿ってChainを再構築する
+*/
+func (b *BlockChain) init(dataDir string) {
+	*b = BlockChain{}
+
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		panic(err)
+	}
+
+	db, err := storage.Open(filepath.Join(dataDir, "blockchain.db"))
+	if err != nil {
+		panic(err)
+	}
+	b.db = db
+
+	tip, err := db.Tip()
+	if err != nil {
+		panic(err)
+	}
+
+	if tip == "" {
+		// ジェネシスブロックを作る
+		b.NewBlock("1", 100, nil, ComputeMerkleRoot(nil), InitialDifficulty)
+		return
+	}
+
+	b.Chain = b.loadChain(tip)
+}
+
+/*
+loadChain tipのハッシュからPreviousHashを辿ってジェネシスブロックまで遡り、
+ Indexの昇順に並んだChainを再構築する
+*/
+func (b *BlockChain) loadChain(tip string) []Block {
+	var chain []Block
+	it := NewBlockChainIterator(b.db, tip)
+
+	for {
+		block, err := it.Next()
+		if err != nil {
+			panic(err)
+		}
+		if block == nil {
+			break
+		}
+		chain = append([]Block{*block}, chain...)
+	}
+
+	return chain
+}
+
+/*
+BlockChainIterator tipから一つずつブロックを辿るイテレータ
+ Chain全体をメモリに載せずにDBから範囲走査したい場合に使う
+*/
+type BlockChainIterator struct {
+	currentHash string
+	db          *storage.DB
+}
+
+/*
+NewBlockChainIterator 指定したハッシュを起点とするイテレータを作る
+*/
+func NewBlockChainIterator(db *storage.DB, fromHash string) *BlockChainIterator {
+	return &BlockChainIterator{currentHash: fromHash, db: db}
+}
+
+/*
+Next 現在位置のブロックを返し、PreviousHashを使って一つ前のブロックへ進める
+ ジェネシスブロックまで辿り切るとnilを返す
+*/
+func (it *BlockChainIterator) Next() (*Block, error) {
+	if it.currentHash == "" {
+		return nil, nil
+	}
+
+	data, err := it.db.GetBlock(it.currentHash)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, nil
+	}
+
+	var block Block
+	if err := json.Unmarshal(data, &block); err != nil {
+		return nil, err
+	}
+
+	it.currentHash = block.PreviousHash
+	return &block, nil
 }
 
 /*
 NewBlock ブロックチェーンに新しいブロックを作る
- :param proof: <int> プルーフオブワークアルゴリズムアルゴリズムから得られるプルーフ
- :prama previousHash <str> 前のブロックのハッシュ
+ txs・merkleRoot・difficultyはMine側で採掘開始前に確定させた値を渡す。
+ 採掘はロックを持たない長時間処理なので、呼び出し側でスナップショットした
+ 値をそのまま使うことで、採掘中にmempoolへ新しいトランザクションが
+ 届いてもMerkleRootとProofの対応がずれない
+ :param proof: <int> プルーフオブワークアルゴリズムから得られるプルーフ
+ :param previousHash: <str> 前のブロックのハッシュ。空文字ならChainの最後のブロックを使う
  :return <dict> 新しいブロック
 */
-func (BlockChain) NewBlock(PreviousHash string, proof int) Block {
-	pg := ""
-	// 一つ前のブロックのハッシュを取得
-	if PreviousHash != "" {
-		pg = PreviousHash
+func (b *BlockChain) NewBlock(previousHash string, proof int, txs []Transaction, merkleRoot string, difficulty int) Block {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	pg := previousHash
+	if pg == "" {
 		// 一つ前のブロックのハッシュがなかった場合、所属するブロックチェーンの最後のブロックをハッシュ化
-	} else {
-		pg = blockchain.Hash(blockchain.Chain[len(blockchain.Chain)-1])
+		pg = computeHash(currentLastBlock())
 	}
-	//新しいブロックを作成
+
 	block := Block{
-		Index:        len(blockchain.Chain) + 1,
+		Index:        len(b.Chain) + 1,
 		Timestamp:    time.Now().Unix(),
-		Transactions: blockchain.CurrentTransaction,
+		Transactions: txs,
+		MerkleRoot:   merkleRoot,
+		Difficulty:   difficulty,
 		Proof:        proof,
 		PreviousHash: pg,
 	}
 
-	blockchain.CurrentTransaction = Transaction{}
-	blockchain.Chain = append(blockchain.Chain, block)
+	// 採掘に使ったぶんだけmempoolから取り除く。採掘中に届いた新しいトランザクションは残す
+	b.CurrentTransactions = removeMined(b.CurrentTransactions, txs)
+	b.Chain = append(b.Chain, block)
+	b.utxoSet.Reindex(b.Chain)
+
+	if b.db != nil {
+		if err := b.persistBlock(block); err != nil {
+			panic(err)
+		}
+	}
+
 	return block
 }
 
 /*
-NewTransaction 新しいトランザクションを作成する
- :param sender: <str> トランザクションの送信者
- :prama recipient <str> トランザクションの受信者
- :param amount <int> トランザクションの取引数量
+removeMined mempoolからminedに含まれるトランザクションを取り除いた新しいスライスを返す
+*/
+func removeMined(mempool []Transaction, mined []Transaction) []Transaction {
+	if len(mempool) == 0 {
+		return mempool
+	}
+
+	var remaining []Transaction
+	for _, tx := range mempool {
+		used := false
+		for _, minedTx := range mined {
+			if bytes.Equal(tx.ID, minedTx.ID) {
+				used = true
+				break
+			}
+		}
+		if !used {
+			remaining = append(remaining, tx)
+		}
+	}
+	return remaining
+}
+
+/*
+persistBlock ブロックをハッシュ値をキーにしてDBへ書き込む
+ ブロック本体の書き込みとtipポインタの更新はstorage.DB.PutBlock内の
+ 単一トランザクションで行われる
+ 呼び出し側がblockchain.muを保持していることを前提とする
+*/
+func (b *BlockChain) persistBlock(block Block) error {
+	data, err := json.Marshal(block)
+	if err != nil {
+		return err
+	}
+	return b.db.PutBlock(computeHash(block), data)
+}
+
+/*
+Pay fromのウォレットの署名でtoへamountを送るトランザクションを組み立てる
+ UTXOSetから十分な未使用出力を集め、余りがあればfrom自身へのお釣りの出力を足す
+*/
+func (b *BlockChain) Pay(from *wallet.Wallet, to string, amount int) (Transaction, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	pubKeyHash := wallet.HashPubKey(from.PublicKey)
+	accumulated, validOutputs := b.utxoSet.FindSpendableOutputs(pubKeyHash, amount)
+
+	if accumulated < amount {
+		return Transaction{}, errors.New("残高が不足しています")
+	}
+
+	var inputs []TxInput
+	var outputs []TxOutput
+
+	for txID, outIndexes := range validOutputs {
+		txIDBytes, err := hex.DecodeString(txID)
+		if err != nil {
+			return Transaction{}, err
+		}
+		for _, outIndex := range outIndexes {
+			inputs = append(inputs, TxInput{PrevTxID: txIDBytes, OutIndex: outIndex, PubKey: from.PublicKey})
+		}
+	}
+
+	outputs = append(outputs, NewTxOutput(amount, to))
+	if accumulated > amount {
+		// お釣りをfrom自身へ戻す
+		outputs = append(outputs, NewTxOutput(accumulated-amount, string(from.GetAddress())))
+	}
+
+	tx := Transaction{Inputs: inputs, Outputs: outputs}
+	tx.ID = tx.Hash()
+
+	prevTXs, err := b.prevTransactions(tx)
+	if err != nil {
+		return Transaction{}, err
+	}
+	if err := tx.Sign(from.PrivateKey, prevTXs); err != nil {
+		return Transaction{}, err
+	}
+
+	return tx, nil
+}
+
+/*
+AddTransaction トランザクションの署名とmempoolでの二重支払いを検証したうえでmempoolへ加える
+*/
+func (b *BlockChain) AddTransaction(tx Transaction) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	prevTXs, err := b.prevTransactions(tx)
+	if err != nil {
+		return err
+	}
+
+	if !tx.Verify(prevTXs) {
+		return errors.New("トランザクションの署名が不正です")
+	}
+
+	for _, in := range tx.Inputs {
+		if spentInMempool(b.CurrentTransactions, in.PrevTxID, in.OutIndex) {
+			return errors.New("同じ出力が既にmempoolの別のトランザクションで使われています")
+		}
+	}
+
+	b.CurrentTransactions = append(b.CurrentTransactions, tx)
+	return nil
+}
+
+/*
+prevTransactions txの各Inputが参照する一つ前のトランザクションをChainから探してIDでまとめる
+ 呼び出し側がblockchain.muを保持していることを前提とする
+*/
+func (b *BlockChain) prevTransactions(tx Transaction) (map[string]Transaction, error) {
+	prevTXs := make(map[string]Transaction)
+	for _, in := range tx.Inputs {
+		prevTx, err := b.findTransaction(in.PrevTxID)
+		if err != nil {
+			return nil, err
+		}
+		prevTXs[string(prevTx.ID)] = prevTx
+	}
+	return prevTXs, nil
+}
 
+/*
+findTransaction Chain全体からIDに一致するトランザクションを探す
+ 呼び出し側がblockchain.muを保持していることを前提とする
 */
-func (BlockChain) NewTransaction(sender string, recipient string, amount int) int {
-	blockchain.CurrentTransaction = Transaction{Sender: sender, Recipient: recipient, Amount: amount}
-	return blockchain.LastBlock().Index + 1
+func (b *BlockChain) findTransaction(id []byte) (Transaction, error) {
+	for _, block := range b.Chain {
+		for _, tx := range block.Transactions {
+			if bytes.Equal(tx.ID, id) {
+				return tx, nil
+			}
+		}
+	}
+	return Transaction{}, errors.New("トランザクションが見つかりません")
 }
 
 /*
 LastBlock ブロックチェーンの最後のブロックを取得する
 */
-func (BlockChain) LastBlock() Block {
+func (b *BlockChain) LastBlock() Block {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return currentLastBlock()
+}
+
+/*
+currentLastBlock Chainの最後のブロックを返す
+ 呼び出し側がblockchain.muを保持していることを前提とする
+*/
+func currentLastBlock() Block {
 	return blockchain.Chain[len(blockchain.Chain)-1]
 }
 
@@ -124,7 +399,16 @@ Hash ブロックのSHA-256ハッシュを作る
  :param block: <dict> ブロック
  :return <str>
 */
-func (BlockChain) Hash(block Block) string {
+func (b *BlockChain) Hash(block Block) string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return computeHash(block)
+}
+
+/*
+computeHash ブロックのSHA-256ハッシュを作る。blockchainの状態には触れないのでロック不要
+*/
+func computeHash(block Block) string {
 	blockJson, err := json.Marshal(block)
 	if err != nil {
 		panic(err)
@@ -134,41 +418,176 @@ func (BlockChain) Hash(block Block) string {
 }
 
 /*
-ProofOfWork プルーフオブワークを行う
+ProofOfWork プルーフオブワークをruntime.NumCPU()個のgoroutineに分担させて行う
+ 各workerはnonce空間をworker数でストライドした互いに素な領域(offset, offset+workers, offset+2*workers, ...)
+ を担当し、最初に見つかったworkerがctxをキャンセルして残りのworkerを止める
+ ctxがキャンセルされた場合(resolveConflictsでより長いチェーンが見つかった場合など)も
+ 即座に探索を打ち切り、okにfalseを返す
+ :param ctx: <context.Context> 採掘を中断するためのコンテキスト
  :param lastProof: <int> ブロックチェーン上の最後のブロックのプルーフ
+ :param merkleRoot: <string> これから採掘するブロックのMerkleRoot
+ :param difficulty: <int> これから採掘するブロックのDifficulty(ターゲットのビット長)
 */
-func (BlockChain) ProofOfWork(lastProof int) int {
-	proof := 0
-	for blockchain.validProof(lastProof, proof) == false {
-		proof++
+func (b *BlockChain) ProofOfWork(ctx context.Context, lastProof int, merkleRoot string, difficulty int) (proof int, ok bool) {
+	workers := runtime.NumCPU()
+	if workers < 1 {
+		workers = 1
+	}
+
+	workerCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	found := make(chan int, workers)
+
+	for i := 0; i < workers; i++ {
+		go func(offset int) {
+			for proof := offset; ; proof += workers {
+				select {
+				case <-workerCtx.Done():
+					return
+				default:
+				}
+
+				if b.validProof(lastProof, proof, merkleRoot, difficulty) {
+					select {
+					case found <- proof:
+					default:
+					}
+					cancel()
+					return
+				}
+			}
+		}(i)
 	}
 
-	return proof
+	select {
+	case proof := <-found:
+		return proof, true
+	case <-ctx.Done():
+		return 0, false
+	}
 }
 
 /*
-validProof ブロックチェーン上の最後のブロックのプルーフと新しいプルーフで検証を行う
+proofOfWorkSequential ProofOfWorkの単一スレッド版。並列版との性能比較ベンチマーク専用
+*/
+func (b *BlockChain) proofOfWorkSequential(ctx context.Context, lastProof int, merkleRoot string, difficulty int) (proof int, ok bool) {
+	for {
+		select {
+		case <-ctx.Done():
+			return 0, false
+		default:
+		}
+
+		if b.validProof(lastProof, proof, merkleRoot, difficulty) {
+			return proof, true
+		}
+		proof++
+	}
+}
+
+/*
+validProof lastProof||proof||merkleRootのハッシュ値を、difficultyから導かれるターゲットと比較する
+ ハッシュ値をbig.Intとして見たとき2^(256-difficulty)未満であれば正当なプルーフとみなす
  :param lastProof: ブロックチェーン上の最後のブロックのプルーフ
  :param proof: 検証対象のプルーフ
+ :param merkleRoot: このブロックのMerkleRoot。プルーフがブロックの中身と結びつくようにハッシュへ含める
+ :param difficulty: ターゲットのビット長
 */
-func (BlockChain) validProof(lastProof int, proof int) bool {
-	guess := []byte(strconv.Itoa(lastProof) + strconv.Itoa(proof))
+func (b *BlockChain) validProof(lastProof int, proof int, merkleRoot string, difficulty int) bool {
+	guess := []byte(strconv.Itoa(lastProof) + strconv.Itoa(proof) + merkleRoot)
 	sha256s := sha256.Sum256(guess)
-	guessHash := hex.EncodeToString(sha256s[:])
-	return guessHash[:4] == "0000"
+	guessInt := new(big.Int).SetBytes(sha256s[:])
+	target := new(big.Int).Lsh(big.NewInt(1), uint(256-difficulty))
+	return guessInt.Cmp(target) < 0
+}
+
+/*
+NextDifficulty 次に採掘するブロックのDifficultyを決める
+ RetargetIntervalブロックごとに、直近RetargetInterval個のブロックにかかった実際の時間と
+ 期待する時間(TargetBlockTimeSeconds*RetargetInterval)の比率でDifficultyを調整する
+ 比率は上下maxRetargetFactor倍にクランプし、急激な乱高下を防ぐ
+*/
+func (b *BlockChain) NextDifficulty() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return nextDifficultyUnsafe()
 }
 
-func (BlockChain) validChain(chain []Block) bool {
+/*
+nextDifficultyUnsafe NextDifficultyの本体。呼び出し側がblockchain.muを保持していることを前提とする
+*/
+func nextDifficultyUnsafe() int {
+	if len(blockchain.Chain) == 0 {
+		return InitialDifficulty
+	}
+
+	last := blockchain.Chain[len(blockchain.Chain)-1]
+
+	if last.Index%RetargetInterval != 0 || len(blockchain.Chain) < RetargetInterval {
+		return last.Difficulty
+	}
+
+	first := blockchain.Chain[len(blockchain.Chain)-RetargetInterval]
+	actualTime := last.Timestamp - first.Timestamp
+	if actualTime <= 0 {
+		actualTime = 1
+	}
+	expectedTime := int64(TargetBlockTimeSeconds * RetargetInterval)
+
+	ratio := float64(expectedTime) / float64(actualTime)
+	if ratio > maxRetargetFactor {
+		ratio = maxRetargetFactor
+	}
+	if ratio < 1.0/maxRetargetFactor {
+		ratio = 1.0 / maxRetargetFactor
+	}
+
+	newDifficulty := int(float64(last.Difficulty) * ratio)
+	if newDifficulty < 1 {
+		newDifficulty = 1
+	}
+	if newDifficulty > 255 {
+		newDifficulty = 255
+	}
+
+	return newDifficulty
+}
+
+func (b *BlockChain) validChain(chain []Block) bool {
 	lastBlock := chain[0]
 	currentIndex := 1
 
 	for currentIndex < len(chain) {
 		block := chain[currentIndex]
 
-		if block.PreviousHash != blockchain.Hash(lastBlock) {
+		if block.PreviousHash != computeHash(lastBlock) {
+			return false
+		}
+
+		if block.MerkleRoot != ComputeMerkleRoot(block.Transactions) {
 			return false
 		}
 
+		if !b.validProof(lastBlock.Proof, block.Proof, block.MerkleRoot, block.Difficulty) {
+			return false
+		}
+
+		for _, tx := range block.Transactions {
+			if tx.IsCoinbase() {
+				continue
+			}
+			prevTXs, err := findPrevTransactions(chain, tx)
+			if err != nil || !tx.Verify(prevTXs) {
+				return false
+			}
+			for _, in := range tx.Inputs {
+				if !in.UsesKey(prevTXs[string(in.PrevTxID)].Outputs[in.OutIndex].PubKeyHash) {
+					return false
+				}
+			}
+		}
+
 		lastBlock = block
 		currentIndex++
 
@@ -176,71 +595,193 @@ func (BlockChain) validChain(chain []Block) bool {
 	return true
 }
 
-func (BlockChain) resolveConflicts() bool {
-	neighbors := blockchain.Nodes
-	var newChain []Block
+/*
+findPrevTransactions txの各Inputが参照する一つ前のトランザクションをchainから探してIDでまとめる
+ validChainでは自分のChainではなく検証対象のchainを辿る必要があるため、findTransactionとは別に持つ
+*/
+func findPrevTransactions(chain []Block, tx Transaction) (map[string]Transaction, error) {
+	prevTXs := make(map[string]Transaction)
+	for _, in := range tx.Inputs {
+		found := false
+		for _, block := range chain {
+			for _, candidate := range block.Transactions {
+				if bytes.Equal(candidate.ID, in.PrevTxID) {
+					prevTXs[string(candidate.ID)] = candidate
+					found = true
+				}
+			}
+		}
+		if !found {
+			return nil, errors.New("トランザクションが見つかりません")
+		}
+	}
+	return prevTXs, nil
+}
 
-	maxLength := len(blockchain.Chain)
+/*
+resolveConflicts 接続中の全ピアに/chain/1.0.0でフルチェーンを問い合わせ、
+ 自分より長く正当なチェーンがあれば採用する
+ かつてのようにHTTPで登録済みノードをポーリングするのではなく、
+ libp2pのピアストアに実際に繋がっているピアへ直接問い合わせる
+*/
+func (b *BlockChain) resolveConflicts() bool {
+	// ピアへの問い合わせはネットワークI/Oを伴い長くかかりうるので、ロックは持たずに行う
+	b.mu.RLock()
+	maxLength := len(b.Chain)
+	b.mu.RUnlock()
 
-	for _, node := range neighbors {
-		response, err := http.Get(node + "/chain")
-		if err != nil {
-			panic(err)
-		}
+	var newChain []Block
 
-		if response.StatusCode != 200 {
-			panic(err)
+	if p2pNode != nil {
+		for _, p := range p2pNode.Peers() {
+			chainJSON, err := p2pNode.RequestChain(context.Background(), p)
+			if err != nil {
+				continue
+			}
+
+			var fullChain FullChain
+			if err := json.Unmarshal(chainJSON, &fullChain); err != nil {
+				continue
+			}
+
+			if fullChain.Length > maxLength && b.validChain(fullChain.Chain) {
+				maxLength = fullChain.Length
+				newChain = fullChain.Chain
+			}
 		}
+	}
 
-		var fullChain FullChain
-		if err := json.NewDecoder(response.Body).Decode(&fullChain); err != nil {
-			panic(err)
-		}
+	if len(newChain) == 0 {
+		return false
+	}
 
-		length := fullChain.Length
-		chain := fullChain.Chain
+	// 実際にChainを書き換える直前だけ書き込みロックを取る
+	b.mu.Lock()
+	defer b.mu.Unlock()
 
-		if length > maxLength && blockchain.validChain(chain) {
-			maxLength = length
-			newChain = chain
-		}
+	// より長い正当なチェーンが見つかったので、採掘中のProofOfWorkがあれば中断する
+	if b.miningCancel != nil {
+		b.miningCancel()
 	}
 
-	if len(newChain) != 0 {
-		blockchain.Chain = newChain
-		return true
+	b.Chain = newChain
+	b.utxoSet.Reindex(b.Chain)
+
+	if b.db != nil {
+		for _, block := range newChain {
+			if err := b.persistBlock(block); err != nil {
+				panic(err)
+			}
+		}
 	}
-	return false
+
+	return true
 }
 
-func (BlockChain) RegisterNode(address string) {
-	blockchain.Nodes = append(blockchain.Nodes, address)
+// p2pNode ブロックとトランザクションのゴシップ、チェーン同期を行うlibp2pノード
+var p2pNode *p2p.Node
 
-	fix := make(map[string]bool)
-	one := []string{}
-	for _, a := range blockchain.Nodes {
-		if !fix[a] {
-			fix[a] = true
-			one = append(one, a)
+/*
+startP2P libp2pノードを起動し、"blocks"/"tx"トピックの購読とChainProtocolIDの応答を開始する
+ :param peersCSV: <string> --peersフラグの値。カンマ区切りのmultiaddr
+*/
+func startP2P(peersCSV string) error {
+	var bootstrapPeers []string
+	if peersCSV != "" {
+		bootstrapPeers = strings.Split(peersCSV, ",")
+	}
+
+	ctx := context.Background()
+	node, err := p2p.NewNode(ctx, []string{"/ip4/0.0.0.0/tcp/0"}, bootstrapPeers)
+	if err != nil {
+		return err
+	}
+	p2pNode = node
+
+	p2pNode.HandleChainRequests(func() ([]byte, error) {
+		blockchain.mu.RLock()
+		defer blockchain.mu.RUnlock()
+		return json.Marshal(FullChain{Chain: blockchain.Chain, Length: len(blockchain.Chain)})
+	})
+
+	if err := p2pNode.SubscribeBlocks(ctx, handleIncomingBlock); err != nil {
+		return err
+	}
+	if err := p2pNode.SubscribeTx(ctx, handleIncomingTx); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+/*
+handleIncomingBlock pubsubの"blocks"トピックから届いたブロックを処理する
+ 自分のChainの次に繋がるブロックならそのまま取り込み、
+ それより先のIndexのブロックならChainが遅れているとみなしてピアに同期を依頼する
+*/
+func handleIncomingBlock(from peer.ID, blockJSON []byte) {
+	var block Block
+	if err := json.Unmarshal(blockJSON, &block); err != nil {
+		return
+	}
 
+	blockchain.mu.Lock()
+	lastBlock := currentLastBlock()
+	needsSync := false
+
+	switch {
+	case block.Index == lastBlock.Index+1 && block.PreviousHash == computeHash(lastBlock):
+		blockchain.CurrentTransactions = removeMined(blockchain.CurrentTransactions, block.Transactions)
+		blockchain.Chain = append(blockchain.Chain, block)
+		blockchain.utxoSet.Reindex(blockchain.Chain)
+		if blockchain.db != nil {
+			_ = blockchain.persistBlock(block)
 		}
+	case block.Index > lastBlock.Index+1:
+		needsSync = true
 	}
-	blockchain.Nodes = one
+	blockchain.mu.Unlock()
+
+	if needsSync {
+		// resolveConflicts自身がロックを取るので、ここでは解放してから呼ぶ
+		blockchain.resolveConflicts()
+	}
+}
+
+/*
+handleIncomingTx pubsubの"tx"トピックから届いたトランザクションを検証してmempoolへ加える
+*/
+func handleIncomingTx(from peer.ID, txJSON []byte) {
+	var tx Transaction
+	if err := json.Unmarshal(txJSON, &tx); err != nil {
+		return
+	}
+	_ = blockchain.AddTransaction(tx)
 }
 
 var nodeIdentifire string
 
 func main() {
+	dataDir := flag.String("datadir", "./data", "ブロックを永続化するBoltDBファイルを置くディレクトリ")
+	peersFlag := flag.String("peers", "", "起動時に接続する既知のピアのmultiaddr(カンマ区切り)")
+	flag.Parse()
+
 	e := echo.New()
 
 	nodeIdentifire = strings.Replace(uuid.New().String(), "-", "", -1)
-	blockchain.init()
+	blockchain.init(*dataDir)
+
+	if err := startP2P(*peersFlag); err != nil {
+		panic(err)
+	}
 
 	e.GET("/mine", Mine)
 	e.POST("/transactions/new", NewTransactionPost)
 	e.GET("/chain", FullChainGET)
-	e.POST("/nodes/register", RegisterNode)
 	e.GET("/nodes/resolve", Consensus)
+	e.GET("/tx/:txid/proof", TxProofGET)
+	e.POST("/tx/verify", TxVerifyPost)
+	e.GET("/difficulty", DifficultyGET)
 
 	go func(echoEcho *echo.Echo) {
 		copyEcho := echoEcho
@@ -249,34 +790,12 @@ func main() {
 	e.Start(":5000")
 }
 
-type Post2 struct {
-	Nodes []string `json:"nodes"`
-}
-
-type Response2 struct {
-	Message   string   `json:"message"`
-	TotalNode []string `json:"total_node"`
-}
-
-func RegisterNode(e echo.Context) error {
-	nodes := new(Post2)
-	if err := e.Bind(nodes); err != nil {
-		return e.JSON(http.StatusBadRequest, "Status Bad Request.")
-	}
-
-	for _, node := range nodes.Nodes {
-		blockchain.RegisterNode(node)
-	}
-
-	var response2 Response2
-	response2.Message = "新しいノードが追加されました"
-	response2.TotalNode = blockchain.Nodes
-
-	return e.JSON(http.StatusCreated, response2)
-}
-
 func Consensus(e echo.Context) error {
 	replaced := blockchain.resolveConflicts()
+
+	blockchain.mu.RLock()
+	defer blockchain.mu.RUnlock()
+
 	if replaced {
 		type Response struct {
 			Message  string  `json:"message"`
@@ -299,46 +818,104 @@ func Consensus(e echo.Context) error {
 }
 
 type Post struct {
-	Sender    string
-	Recipient string
-	Amount    int
+	// クライアント側のウォレットで既に署名済みのトランザクション
+	Transaction Transaction `json:"transaction"`
 }
 
+/*
+NewTransactionPost 署名済みのトランザクションを受け取り、検証してからmempoolへ加える
+ 秘密鍵はクライアント側のwallet.Walletが持ったままなので、ここでは署名の検証だけを行う
+*/
 func NewTransactionPost(e echo.Context) error {
 	post := new(Post)
 	if err := e.Bind(post); err != nil {
 		return e.JSON(http.StatusBadRequest, "Status Bad Request.")
 	}
 
-	index := blockchain.NewTransaction(post.Sender, post.Recipient, post.Amount)
-	return e.JSON(http.StatusCreated, "トランザクションはブロック"+strconv.Itoa(index)+"に追加されました")
+	if err := blockchain.AddTransaction(post.Transaction); err != nil {
+		return e.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	if p2pNode != nil {
+		if txJSON, err := json.Marshal(post.Transaction); err == nil {
+			_ = p2pNode.PublishTx(context.Background(), txJSON)
+		}
+	}
+
+	return e.JSON(http.StatusCreated, "トランザクションがmempoolに追加されました")
 }
 
 func Mine(e echo.Context) error {
-	lastBlock := blockchain.LastBlock()
+	// 採掘中に他のリクエストがmempoolへ新しいトランザクションを足せるよう、
+	// これから採掘するぶんのトランザクションとMerkleRootを先にスナップショットしておく
+	blockchain.mu.Lock()
+	lastBlock := currentLastBlock()
 	lastProof := lastBlock.Proof
-	proof := blockchain.ProofOfWork(lastProof)
+	difficulty := nextDifficultyUnsafe()
 
-	blockchain.NewTransaction("0", nodeIdentifire, 1)
-	block := blockchain.NewBlock("", proof)
+	coinbase := NewCoinbaseTx(nodeIdentifire, MiningReward)
+	txs := append(append([]Transaction{}, blockchain.CurrentTransactions...), coinbase)
+	merkleRoot := ComputeMerkleRoot(txs)
+	blockchain.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	blockchain.mu.Lock()
+	blockchain.miningCancel = cancel
+	blockchain.mu.Unlock()
+
+	proof, ok := blockchain.ProofOfWork(ctx, lastProof, merkleRoot, difficulty)
+
+	blockchain.mu.Lock()
+	blockchain.miningCancel = nil
+	blockchain.mu.Unlock()
+	cancel()
+
+	if !ok {
+		return e.JSON(http.StatusConflict, "より長いチェーンに追従したため採掘を中断しました")
+	}
+
+	block := blockchain.NewBlock("", proof, txs, merkleRoot, difficulty)
+
+	if p2pNode != nil {
+		if blockJSON, err := json.Marshal(block); err == nil {
+			_ = p2pNode.PublishBlock(context.Background(), blockJSON)
+		}
+	}
 
 	response := struct {
-		Message      string      `json:"Message"`
-		Index        int         `json:"index"`
-		Transactions Transaction `json:"transactions"`
-		Proof        int         `json:"proof"`
-		PreviousHash string      `json:"previous_hash"`
+		Message      string        `json:"Message"`
+		Index        int           `json:"index"`
+		Transactions []Transaction `json:"transactions"`
+		MerkleRoot   string        `json:"merkle_root"`
+		Difficulty   int           `json:"difficulty"`
+		Proof        int           `json:"proof"`
+		PreviousHash string        `json:"previous_hash"`
 	}{
 		Message:      "新しいブロックを採掘しました",
 		Index:        block.Index,
 		Transactions: block.Transactions,
+		MerkleRoot:   block.MerkleRoot,
+		Difficulty:   block.Difficulty,
 		Proof:        block.Proof,
 		PreviousHash: block.PreviousHash,
 	}
 	return e.JSON(http.StatusCreated, response)
 }
 
+/*
+DifficultyGET 次に採掘するブロックの現在のDifficulty(ターゲットのビット長)を返す
+*/
+func DifficultyGET(e echo.Context) error {
+	response := struct {
+		Difficulty int `json:"difficulty"`
+	}{Difficulty: blockchain.NextDifficulty()}
+	return e.JSON(http.StatusOK, response)
+}
+
 func FullChainGET(e echo.Context) error {
+	blockchain.mu.RLock()
+	defer blockchain.mu.RUnlock()
+
 	var response FullChain
 	response.Chain = blockchain.Chain
 	response.Length = len(blockchain.Chain)
@@ -0,0 +1,151 @@
+package merkle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+)
+
+/*
+MerkleNode マークル木を構成するノード
+ 葉ノードはDataのハッシュを、内部ノードは左右の子のハッシュを連結したもののハッシュを持つ
+*/
+type MerkleNode struct {
+	Left  *MerkleNode
+	Right *MerkleNode
+	Hash  []byte
+}
+
+/*
+NewMerkleNode データまたは左右の子からMerkleNodeを作る
+ leftとrightが両方nilの場合は葉ノードとしてdataのハッシュを使う
+*/
+func NewMerkleNode(left, right *MerkleNode, data []byte) *MerkleNode {
+	node := &MerkleNode{Left: left, Right: right}
+
+	if left == nil && right == nil {
+		hash := sha256.Sum256(data)
+		node.Hash = hash[:]
+		return node
+	}
+
+	combined := append(append([]byte{}, left.Hash...), right.Hash...)
+	hash := sha256.Sum256(combined)
+	node.Hash = hash[:]
+	return node
+}
+
+/*
+NewTree dataの各要素を葉とするマークル木を作り、ルートノードを返す
+ 葉の数が奇数の場合は最後の葉を複製して偶数に揃える
+*/
+func NewTree(data [][]byte) *MerkleNode {
+	if len(data) == 0 {
+		return NewMerkleNode(nil, nil, []byte{})
+	}
+
+	if len(data)%2 != 0 {
+		data = append(data, data[len(data)-1])
+	}
+
+	var nodes []*MerkleNode
+	for _, d := range data {
+		nodes = append(nodes, NewMerkleNode(nil, nil, d))
+	}
+
+	for len(nodes) > 1 {
+		if len(nodes)%2 != 0 {
+			nodes = append(nodes, nodes[len(nodes)-1])
+		}
+
+		var level []*MerkleNode
+		for i := 0; i < len(nodes); i += 2 {
+			level = append(level, NewMerkleNode(nodes[i], nodes[i+1], nil))
+		}
+		nodes = level
+	}
+
+	return nodes[0]
+}
+
+/*
+ProofStep SPV検証のために、あるリーフからルートまでの経路上にある兄弟ハッシュ1つ分
+*/
+type ProofStep struct {
+	// 兄弟ノードのハッシュ値
+	Hash []byte
+	// 兄弟ノードが自分より左側にあるか(falseなら右側)
+	SiblingIsLeft bool
+}
+
+/*
+BuildProof leaves[index]からルートまでの兄弟ハッシュの列を、葉から順に積み上げて返す
+ 軽量クライアントがフルブロックをダウンロードせずにトランザクションの包含を検証するために使う
+*/
+func BuildProof(leaves [][]byte, index int) ([]ProofStep, error) {
+	if index < 0 || index >= len(leaves) {
+		return nil, errors.New("merkle: index out of range")
+	}
+
+	// NewTreeと同じく、葉の数が奇数(1枚だけの場合を含む)なら最後の葉を複製して偶数に揃えてからハッシュ化する
+	paddedLeaves := leaves
+	if len(paddedLeaves)%2 != 0 {
+		paddedLeaves = append(append([][]byte{}, paddedLeaves...), paddedLeaves[len(paddedLeaves)-1])
+	}
+
+	level := make([][]byte, len(paddedLeaves))
+	for i, d := range paddedLeaves {
+		hash := sha256.Sum256(d)
+		level[i] = hash[:]
+	}
+
+	var proof []ProofStep
+	idx := index
+
+	for len(level) > 1 {
+		if len(level)%2 != 0 {
+			level = append(level, level[len(level)-1])
+		}
+
+		isRightChild := idx%2 == 1
+		var step ProofStep
+		if isRightChild {
+			step = ProofStep{Hash: level[idx-1], SiblingIsLeft: true}
+		} else {
+			step = ProofStep{Hash: level[idx+1], SiblingIsLeft: false}
+		}
+		proof = append(proof, step)
+
+		var next [][]byte
+		for i := 0; i < len(level); i += 2 {
+			combined := append(append([]byte{}, level[i]...), level[i+1]...)
+			hash := sha256.Sum256(combined)
+			next = append(next, hash[:])
+		}
+		level = next
+		idx /= 2
+	}
+
+	return proof, nil
+}
+
+/*
+VerifyProof leafのハッシュからproofを順に適用してルートハッシュを再計算し、rootと一致するか調べる
+*/
+func VerifyProof(leaf []byte, proof []ProofStep, root []byte) bool {
+	hash := sha256.Sum256(leaf)
+	current := hash[:]
+
+	for _, step := range proof {
+		var combined []byte
+		if step.SiblingIsLeft {
+			combined = append(append([]byte{}, step.Hash...), current...)
+		} else {
+			combined = append(append([]byte{}, current...), step.Hash...)
+		}
+		hash := sha256.Sum256(combined)
+		current = hash[:]
+	}
+
+	return bytes.Equal(current, root)
+}
@@ -0,0 +1,49 @@
+package merkle
+
+import "testing"
+
+func TestVerifyProofSingleLeaf(t *testing.T) {
+	leaves := [][]byte{[]byte("only-tx")}
+
+	root := NewTree(leaves)
+	proof, err := BuildProof(leaves, 0)
+	if err != nil {
+		t.Fatalf("BuildProof returned error: %v", err)
+	}
+
+	if !VerifyProof(leaves[0], proof, root.Hash) {
+		t.Fatalf("VerifyProof = false, want true for a single-leaf tree")
+	}
+}
+
+func TestVerifyProofEvenLeaves(t *testing.T) {
+	leaves := [][]byte{[]byte("tx-a"), []byte("tx-b"), []byte("tx-c"), []byte("tx-d")}
+
+	root := NewTree(leaves)
+
+	for i, leaf := range leaves {
+		proof, err := BuildProof(leaves, i)
+		if err != nil {
+			t.Fatalf("BuildProof(%d) returned error: %v", i, err)
+		}
+		if !VerifyProof(leaf, proof, root.Hash) {
+			t.Fatalf("VerifyProof = false for leaf %d, want true", i)
+		}
+	}
+}
+
+func TestVerifyProofOddLeaves(t *testing.T) {
+	leaves := [][]byte{[]byte("tx-a"), []byte("tx-b"), []byte("tx-c")}
+
+	root := NewTree(leaves)
+
+	for i, leaf := range leaves {
+		proof, err := BuildProof(leaves, i)
+		if err != nil {
+			t.Fatalf("BuildProof(%d) returned error: %v", i, err)
+		}
+		if !VerifyProof(leaf, proof, root.Hash) {
+			t.Fatalf("VerifyProof = false for leaf %d, want true", i)
+		}
+	}
+}
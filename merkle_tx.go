@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/labstack/echo"
+	"github.com/soichiro0311/BlockChain/merkle"
+)
+
+/*
+ComputeMerkleRoot txsのIDを葉にしたマークル木を作り、ルートハッシュを16進文字列で返す
+ txsが空の場合は空データのSHA-256ハッシュをルートとして使う
+*/
+func ComputeMerkleRoot(txs []Transaction) string {
+	leaves := txIDs(txs)
+	root := merkle.NewTree(leaves)
+	return hex.EncodeToString(root.Hash)
+}
+
+func txIDs(txs []Transaction) [][]byte {
+	leaves := make([][]byte, len(txs))
+	for i, tx := range txs {
+		leaves[i] = tx.ID
+	}
+	return leaves
+}
+
+/*
+ProofResponse GET /tx/:txid/proof のレスポンス
+*/
+type ProofResponse struct {
+	TxID       string          `json:"txid"`
+	BlockIndex int             `json:"block_index"`
+	MerkleRoot string          `json:"merkle_root"`
+	Proof      []ProofStepJSON `json:"proof"`
+}
+
+/*
+ProofStepJSON merkle.ProofStepのJSON表現。ハッシュは16進文字列で表す
+*/
+type ProofStepJSON struct {
+	Hash   string `json:"hash"`
+	IsLeft bool   `json:"is_left"`
+}
+
+/*
+TxProofGET 指定したtxidを含むブロックを探し、そのブロック内でのマークル証明を返す
+ 軽量クライアントはこのレスポンスだけでフルブロックなしにトランザクションの包含を検証できる
+*/
+func TxProofGET(e echo.Context) error {
+	txidHex := e.Param("txid")
+	txid, err := hex.DecodeString(txidHex)
+	if err != nil {
+		return e.JSON(http.StatusBadRequest, "txidが不正です")
+	}
+
+	blockchain.mu.RLock()
+	defer blockchain.mu.RUnlock()
+
+	for _, block := range blockchain.Chain {
+		leaves := txIDs(block.Transactions)
+		for i, leaf := range leaves {
+			if !bytes.Equal(leaf, txid) {
+				continue
+			}
+
+			proof, err := merkle.BuildProof(leaves, i)
+			if err != nil {
+				return e.JSON(http.StatusInternalServerError, err.Error())
+			}
+
+			response := ProofResponse{
+				TxID:       txidHex,
+				BlockIndex: block.Index,
+				MerkleRoot: block.MerkleRoot,
+				Proof:      toProofStepJSON(proof),
+			}
+			return e.JSON(http.StatusOK, response)
+		}
+	}
+
+	return e.JSON(http.StatusNotFound, "指定されたトランザクションを含むブロックが見つかりません")
+}
+
+func toProofStepJSON(proof []merkle.ProofStep) []ProofStepJSON {
+	steps := make([]ProofStepJSON, len(proof))
+	for i, step := range proof {
+		steps[i] = ProofStepJSON{Hash: hex.EncodeToString(step.Hash), IsLeft: step.SiblingIsLeft}
+	}
+	return steps
+}
+
+/*
+VerifyProofPost POST /tx/verify のリクエストボディ
+*/
+type VerifyProofPost struct {
+	TxID       string          `json:"txid"`
+	Proof      []ProofStepJSON `json:"proof"`
+	BlockIndex int             `json:"block_index"`
+}
+
+/*
+TxVerifyPost 与えられたtxidとproofからルートハッシュを再計算し、
+ 該当ブロックのヘッダーにあるMerkleRootと一致するか検証する
+*/
+func TxVerifyPost(e echo.Context) error {
+	post := new(VerifyProofPost)
+	if err := e.Bind(post); err != nil {
+		return e.JSON(http.StatusBadRequest, "Status Bad Request.")
+	}
+
+	txid, err := hex.DecodeString(post.TxID)
+	if err != nil {
+		return e.JSON(http.StatusBadRequest, "txidが不正です")
+	}
+
+	blockchain.mu.RLock()
+	var target *Block
+	for i := range blockchain.Chain {
+		if blockchain.Chain[i].Index == post.BlockIndex {
+			block := blockchain.Chain[i]
+			target = &block
+			break
+		}
+	}
+	blockchain.mu.RUnlock()
+	if target == nil {
+		return e.JSON(http.StatusNotFound, "指定されたインデックスのブロックが見つかりません")
+	}
+
+	root, err := hex.DecodeString(target.MerkleRoot)
+	if err != nil {
+		return e.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	proof := make([]merkle.ProofStep, len(post.Proof))
+	for i, step := range post.Proof {
+		hash, err := hex.DecodeString(step.Hash)
+		if err != nil {
+			return e.JSON(http.StatusBadRequest, "proofのハッシュが不正です")
+		}
+		proof[i] = merkle.ProofStep{Hash: hash, SiblingIsLeft: step.IsLeft}
+	}
+
+	verified := merkle.VerifyProof(txid, proof, root)
+
+	response := struct {
+		Verified bool `json:"verified"`
+	}{Verified: verified}
+	return e.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,60 @@
+package p2p
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// ChainProtocolID 要求してきたピアへ自分の持つフルチェーンを返すプロトコル
+const ChainProtocolID protocol.ID = "/chain/1.0.0"
+
+/*
+ChainRequestHandler 自ノードの現在のフルチェーンをJSONバイト列で返す関数
+*/
+type ChainRequestHandler func() ([]byte, error)
+
+/*
+HandleChainRequests ChainProtocolIDへのストリームを受け付け、handlerが返したチェーンを書き戻す
+*/
+func (n *Node) HandleChainRequests(handler ChainRequestHandler) {
+	n.host.SetStreamHandler(ChainProtocolID, func(s network.Stream) {
+		defer s.Close()
+
+		chainJSON, err := handler()
+		if err != nil {
+			return
+		}
+
+		if _, err := s.Write(chainJSON); err != nil {
+			return
+		}
+	})
+}
+
+/*
+RequestChain 指定したピアに/chain/1.0.0ストリームを開き、フルチェーンのJSONバイト列を取得する
+ 高いIndexのブロックを受け取ってChainの追いつきが必要になったときに使う
+*/
+func (n *Node) RequestChain(ctx context.Context, p peer.ID) ([]byte, error) {
+	s, err := n.host.NewStream(ctx, p, ChainProtocolID)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	reader := bufio.NewReader(s)
+	return ioutil.ReadAll(reader)
+}
+
+/*
+Peers 現在接続しているピアのID一覧を返す
+ HTTPポーリングの代わりに、チェーン同期をどのピアへ依頼するか決めるのに使う
+*/
+func (n *Node) Peers() []peer.ID {
+	return n.host.Network().Peers()
+}
@@ -0,0 +1,81 @@
+package p2p
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+/*
+Envelope pubsubで流すメッセージの署名付きラッパー
+ pubsub自体もメッセージ署名を行うが、検証前に偽造されたブロック/トランザクションを
+ アプリケーション層で即座に捨てられるよう、ペイロードにも送信者の鍵で署名を付ける
+ PubKeyはEnvelope自身の中身ではなく、pubsubが報告した送信元のpeer.IDと
+ 一致するかをOpenEnvelopeで必ず照合する。さもないと誰でも好きな鍵で自己完結した
+ 署名を作れてしまい、このラッパーがpubsubの送信元認証に何も追加しないことになる
+*/
+type Envelope struct {
+	// 実際のメッセージ(ブロックやトランザクションをJSONエンコードしたもの)
+	Payload []byte `json:"payload"`
+	// Payloadに対する送信者の署名
+	Signature []byte `json:"signature"`
+	// 署名の検証に使う送信者の公開鍵(libp2pの鍵形式でマーシャルしたもの)
+	PubKey []byte `json:"pub_key"`
+}
+
+/*
+SignEnvelope payloadにprivKeyで署名し、送信可能なEnvelopeのバイト列にする
+*/
+func SignEnvelope(privKey crypto.PrivKey, payload []byte) ([]byte, error) {
+	signature, err := privKey.Sign(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	pubKeyBytes, err := crypto.MarshalPublicKey(privKey.GetPublic())
+	if err != nil {
+		return nil, err
+	}
+
+	envelope := Envelope{Payload: payload, Signature: signature, PubKey: pubKeyBytes}
+	return json.Marshal(envelope)
+}
+
+/*
+OpenEnvelope Envelopeのバイト列を復元し、expectedSender(pubsubが報告したmsg.ReceivedFrom)
+ から送られたものとして署名を検証したうえでPayloadを返す
+ PubKeyがexpectedSenderのpeer.IDへ変換できない場合や署名が不正な場合はエラーを返し、
+ 呼び出し側は中身を一切信用してはならない。PubKeyの照合を省くと、攻撃者は
+ 自分で作ったキーペアで自己完結した署名を付けるだけで検証を通過できてしまう
+*/
+func OpenEnvelope(data []byte, expectedSender peer.ID) ([]byte, error) {
+	var envelope Envelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, err
+	}
+
+	pubKey, err := crypto.UnmarshalPublicKey(envelope.PubKey)
+	if err != nil {
+		return nil, err
+	}
+
+	senderID, err := peer.IDFromPublicKey(pubKey)
+	if err != nil {
+		return nil, err
+	}
+	if senderID != expectedSender {
+		return nil, errors.New("p2p: envelopeのPubKeyが送信元のpeer.IDと一致しません")
+	}
+
+	ok, err := pubKey.Verify(envelope.Payload, envelope.Signature)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("p2p: envelopeの署名が不正です")
+	}
+
+	return envelope.Payload, nil
+}
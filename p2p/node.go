@@ -0,0 +1,200 @@
+package p2p
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	discovery "github.com/libp2p/go-libp2p-discovery"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	multiaddr "github.com/multiformats/go-multiaddr"
+)
+
+// BlocksTopic 採掘されたばかりのブロックを流すpubsubトピック
+const BlocksTopic = "blocks"
+
+// TxTopic mempoolに入った未承認トランザクションを流すpubsubトピック
+const TxTopic = "tx"
+
+// rendezvous DHTでピアを探すときの合言葉
+const rendezvous = "blockchain-go/chunk0-5"
+
+/*
+Node ブロックチェーンのゴシップネットワーキングを担うlibp2pノード
+*/
+type Node struct {
+	host host.Host
+	dht  *dht.IpfsDHT
+	ps   *pubsub.PubSub
+
+	blocksTopic *pubsub.Topic
+	txTopic     *pubsub.Topic
+}
+
+/*
+NewNode libp2pホストを作り、DHTでピアを探索しつつbootstrapPeersへ接続し、
+ "blocks"/"tx"のgossipsubトピックに参加したNodeを返す
+ :param listenAddrs: <[]string> 待受けに使うmultiaddr(例: "/ip4/0.0.0.0/tcp/0")
+ :param bootstrapPeers: <[]string> 起動時に接続する既知のピアのmultiaddr(--peersフラグ由来)
+*/
+func NewNode(ctx context.Context, listenAddrs []string, bootstrapPeers []string) (*Node, error) {
+	h, err := libp2p.New(
+		libp2p.ListenAddrStrings(listenAddrs...),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	kadDHT, err := dht.New(ctx, h)
+	if err != nil {
+		return nil, err
+	}
+	if err := kadDHT.Bootstrap(ctx); err != nil {
+		return nil, err
+	}
+
+	for _, addr := range bootstrapPeers {
+		info, err := peerInfoFromMultiaddr(addr)
+		if err != nil {
+			return nil, err
+		}
+		if err := h.Connect(ctx, *info); err != nil {
+			return nil, fmt.Errorf("p2p: ピア%sへの接続に失敗しました: %w", addr, err)
+		}
+	}
+
+	ps, err := pubsub.NewGossipSub(ctx, h)
+	if err != nil {
+		return nil, err
+	}
+
+	blocksTopic, err := ps.Join(BlocksTopic)
+	if err != nil {
+		return nil, err
+	}
+	txTopic, err := ps.Join(TxTopic)
+	if err != nil {
+		return nil, err
+	}
+
+	node := &Node{host: h, dht: kadDHT, ps: ps, blocksTopic: blocksTopic, txTopic: txTopic}
+
+	routingDiscovery := discovery.NewRoutingDiscovery(kadDHT)
+	discovery.Advertise(ctx, routingDiscovery, rendezvous)
+	go node.discoverPeers(ctx, routingDiscovery)
+
+	return node, nil
+}
+
+/*
+discoverPeers rendezvousに広告している他のピアをDHT経由で探し続け、見つかったら接続する
+*/
+func (n *Node) discoverPeers(ctx context.Context, routingDiscovery *discovery.RoutingDiscovery) {
+	peerChan, err := routingDiscovery.FindPeers(ctx, rendezvous)
+	if err != nil {
+		return
+	}
+
+	for p := range peerChan {
+		if p.ID == n.host.ID() {
+			continue
+		}
+		_ = n.host.Connect(ctx, p)
+	}
+}
+
+func peerInfoFromMultiaddr(addr string) (*peer.AddrInfo, error) {
+	maddr, err := multiaddr.NewMultiaddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	return peer.AddrInfoFromP2pAddr(maddr)
+}
+
+/*
+Host このNodeが持つlibp2pホストを返す
+*/
+func (n *Node) Host() host.Host {
+	return n.host
+}
+
+/*
+Close ホストとDHTをクローズする
+*/
+func (n *Node) Close() error {
+	if err := n.dht.Close(); err != nil {
+		return err
+	}
+	return n.host.Close()
+}
+
+/*
+PublishBlock 採掘したばかりのブロックのJSONバイト列に署名し、"blocks"トピックへ発行する
+*/
+func (n *Node) PublishBlock(ctx context.Context, blockJSON []byte) error {
+	envelope, err := SignEnvelope(n.host.Peerstore().PrivKey(n.host.ID()), blockJSON)
+	if err != nil {
+		return err
+	}
+	return n.blocksTopic.Publish(ctx, envelope)
+}
+
+/*
+PublishTx mempoolに加えたトランザクションのJSONバイト列に署名し、"tx"トピックへ発行する
+*/
+func (n *Node) PublishTx(ctx context.Context, txJSON []byte) error {
+	envelope, err := SignEnvelope(n.host.Peerstore().PrivKey(n.host.ID()), txJSON)
+	if err != nil {
+		return err
+	}
+	return n.txTopic.Publish(ctx, envelope)
+}
+
+/*
+SubscribeBlocks "blocks"トピックを購読し、署名を検証できたメッセージだけhandlerへ渡す
+ 自分自身が発行したメッセージは無視する
+*/
+func (n *Node) SubscribeBlocks(ctx context.Context, handler func(from peer.ID, blockJSON []byte)) error {
+	sub, err := n.blocksTopic.Subscribe()
+	if err != nil {
+		return err
+	}
+	go n.readLoop(ctx, sub, handler)
+	return nil
+}
+
+/*
+SubscribeTx "tx"トピックを購読し、署名を検証できたメッセージだけhandlerへ渡す
+ 自分自身が発行したメッセージは無視する
+*/
+func (n *Node) SubscribeTx(ctx context.Context, handler func(from peer.ID, txJSON []byte)) error {
+	sub, err := n.txTopic.Subscribe()
+	if err != nil {
+		return err
+	}
+	go n.readLoop(ctx, sub, handler)
+	return nil
+}
+
+func (n *Node) readLoop(ctx context.Context, sub *pubsub.Subscription, handler func(from peer.ID, payload []byte)) {
+	for {
+		msg, err := sub.Next(ctx)
+		if err != nil {
+			return
+		}
+		if msg.ReceivedFrom == n.host.ID() {
+			continue
+		}
+
+		payload, err := OpenEnvelope(msg.Data, msg.ReceivedFrom)
+		if err != nil {
+			// 署名検証に失敗した偽造メッセージ・送信元と一致しないPubKeyのメッセージは中身を見ずに捨てる
+			continue
+		}
+
+		handler(msg.ReceivedFrom, payload)
+	}
+}
@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"github.com/boltdb/bolt"
+)
+
+const blocksBucket = "blocks"
+const tipKey = "l"
+
+/*
+DB ブロックチェーンの永続化を担うBoltDBのラッパー
+*/
+type DB struct {
+	bolt *bolt.DB
+}
+
+/*
+Open 指定されたパスにBoltDBファイルを開き、blocksバケットを用意する
+ :param path: <string> DBファイルのパス
+*/
+func Open(path string) (*DB, error) {
+	boltDB, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = boltDB.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(blocksBucket))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &DB{bolt: boltDB}, nil
+}
+
+/*
+Close DBをクローズする
+*/
+func (d *DB) Close() error {
+	return d.bolt.Close()
+}
+
+/*
+Tip 現在のチェーンの先端(tip)が指すブロックのハッシュを取得する
+ バケットが空の場合は空文字列を返す
+*/
+func (d *DB) Tip() (string, error) {
+	var tip string
+	err := d.bolt.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		tip = string(b.Get([]byte(tipKey)))
+		return nil
+	})
+	return tip, err
+}
+
+/*
+GetBlock ハッシュ値に対応するブロックのバイト列を取得する
+ 見つからない場合はnilを返す
+*/
+func (d *DB) GetBlock(hash string) ([]byte, error) {
+	var data []byte
+	err := d.bolt.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		data = b.Get([]byte(hash))
+		return nil
+	})
+	return data, err
+}
+
+/*
+PutBlock ブロックを書き込み、tipも新しいブロックのハッシュへ更新する
+ ブロック本体の書き込みとtipの更新を同一トランザクション内で行うため、
+ 書き込み途中でクラッシュしてもtipが未書き込みのブロックを指すことはない
+*/
+func (d *DB) PutBlock(hash string, data []byte) error {
+	return d.bolt.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		if err := b.Put([]byte(hash), data); err != nil {
+			return err
+		}
+		return b.Put([]byte(tipKey), []byte(hash))
+	})
+}
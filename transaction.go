@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/gob"
+	"errors"
+	"math/big"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/soichiro0311/BlockChain/wallet"
+)
+
+/*
+TxInput トランザクションの入力
+ 一つ前のトランザクションのどの出力を使うかと、それを使う権利を証明する署名・公開鍵を持つ
+*/
+type TxInput struct {
+	// 参照する一つ前のトランザクションのID
+	PrevTxID []byte
+	// 参照する一つ前のトランザクションの出力インデックス
+	OutIndex int
+	// このInputがOutputを使う権利を持つことを証明する署名
+	Signature []byte
+	// 署名の検証に使う公開鍵
+	PubKey []byte
+}
+
+/*
+TxOutput トランザクションの出力
+ 送り先のPubKeyHashへロックされた取引数量を表す
+*/
+type TxOutput struct {
+	// この出力が表す取引数量
+	Value int
+	// この出力をロックする送り先のPubKeyHash
+	PubKeyHash []byte
+}
+
+/*
+Transaction UTXOモデルのトランザクション
+*/
+type Transaction struct {
+	// トランザクションのID(Inputs/Outputsのハッシュ値)
+	ID []byte
+	// 参照するUTXOの配列
+	Inputs []TxInput
+	// 新しく生み出すUTXOの配列
+	Outputs []TxOutput
+}
+
+/*
+NewTxOutput 送り先アドレスへロックされたTxOutputを作る
+*/
+func NewTxOutput(value int, address string) TxOutput {
+	txo := TxOutput{Value: value}
+	txo.Lock(address)
+	return txo
+}
+
+/*
+Lock アドレスから取り出したPubKeyHashでこの出力をロックする
+*/
+func (out *TxOutput) Lock(address string) {
+	out.PubKeyHash = wallet.PubKeyHashFromAddress(address)
+}
+
+/*
+IsLockedWithKey この出力が指定のPubKeyHashでロックされているかを調べる
+*/
+func (out TxOutput) IsLockedWithKey(pubKeyHash []byte) bool {
+	return bytes.Equal(out.PubKeyHash, pubKeyHash)
+}
+
+/*
+UsesKey この入力が指定の公開鍵のハッシュによって使われているかを調べる
+*/
+func (in TxInput) UsesKey(pubKeyHash []byte) bool {
+	return bytes.Equal(wallet.HashPubKey(in.PubKey), pubKeyHash)
+}
+
+/*
+IsCoinbase このトランザクションが採掘報酬のコインベーストランザクションかを調べる
+ コインベースは参照する一つ前のトランザクションを持たない
+*/
+func (tx Transaction) IsCoinbase() bool {
+	return len(tx.Inputs) == 1 && len(tx.Inputs[0].PrevTxID) == 0
+}
+
+/*
+NewCoinbaseTx 採掘報酬として新しいコインをtoへ発行するコインベーストランザクションを作る
+*/
+func NewCoinbaseTx(to string, reward int) Transaction {
+	txin := TxInput{PrevTxID: []byte{}, OutIndex: -1, Signature: nil, PubKey: nil}
+	txout := NewTxOutput(reward, to)
+
+	tx := Transaction{Inputs: []TxInput{txin}, Outputs: []TxOutput{txout}}
+	tx.ID = tx.Hash()
+
+	return tx
+}
+
+/*
+Hash TransactionをシリアライズしてSHA-256ハッシュを計算する
+ IDの採番とSign/Verifyで署名対象を特定する際に使う
+*/
+func (tx Transaction) Hash() []byte {
+	txCopy := tx
+	txCopy.ID = []byte{}
+
+	var encoded bytes.Buffer
+	enc := gob.NewEncoder(&encoded)
+	if err := enc.Encode(txCopy); err != nil {
+		panic(err)
+	}
+
+	hash := sha256.Sum256(encoded.Bytes())
+	return hash[:]
+}
+
+/*
+TrimmedCopy 署名対象を作るため、Signature/PubKeyを取り除いたコピーを作る
+*/
+func (tx Transaction) TrimmedCopy() Transaction {
+	var inputs []TxInput
+	var outputs []TxOutput
+
+	for _, in := range tx.Inputs {
+		inputs = append(inputs, TxInput{PrevTxID: in.PrevTxID, OutIndex: in.OutIndex, Signature: nil, PubKey: nil})
+	}
+
+	for _, out := range tx.Outputs {
+		outputs = append(outputs, out)
+	}
+
+	return Transaction{ID: tx.ID, Inputs: inputs, Outputs: outputs}
+}
+
+/*
+Sign 参照する一つ前のトランザクション(prevTXs)を使って各Inputに署名する
+ 署名対象は、対象Inputだけ参照先のPubKeyHashを仮置きしたトリム済みコピーのハッシュ
+*/
+func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]Transaction) error {
+	if tx.IsCoinbase() {
+		return nil
+	}
+
+	for _, in := range tx.Inputs {
+		if prevTXs[string(in.PrevTxID)].ID == nil {
+			return errors.New("参照する一つ前のトランザクションが見つかりません")
+		}
+	}
+
+	txCopy := tx.TrimmedCopy()
+
+	for inID, in := range txCopy.Inputs {
+		prevTx := prevTXs[string(in.PrevTxID)]
+		txCopy.Inputs[inID].Signature = nil
+		txCopy.Inputs[inID].PubKey = prevTx.Outputs[in.OutIndex].PubKeyHash
+		txCopy.ID = txCopy.Hash()
+		txCopy.Inputs[inID].PubKey = nil
+
+		r, s, err := ecdsa.Sign(rand.Reader, &privKey, txCopy.ID)
+		if err != nil {
+			return err
+		}
+		signature := append(padToFieldByteLen(r), padToFieldByteLen(s)...)
+		pubKey := append(padToFieldByteLen(privKey.PublicKey.X), padToFieldByteLen(privKey.PublicKey.Y)...)
+
+		tx.Inputs[inID].Signature = signature
+		tx.Inputs[inID].PubKey = pubKey
+	}
+
+	return nil
+}
+
+/*
+Verify 参照する一つ前のトランザクション(prevTXs)を使って各Inputの署名を検証する
+*/
+func (tx Transaction) Verify(prevTXs map[string]Transaction) bool {
+	if tx.IsCoinbase() {
+		return true
+	}
+
+	for _, in := range tx.Inputs {
+		if prevTXs[string(in.PrevTxID)].ID == nil {
+			return false
+		}
+	}
+
+	txCopy := tx.TrimmedCopy()
+	curve := btcec.S256()
+
+	for inID, in := range tx.Inputs {
+		prevTx := prevTXs[string(in.PrevTxID)]
+		if !in.UsesKey(prevTx.Outputs[in.OutIndex].PubKeyHash) {
+			return false
+		}
+
+		txCopy.Inputs[inID].Signature = nil
+		txCopy.Inputs[inID].PubKey = prevTx.Outputs[in.OutIndex].PubKeyHash
+		txCopy.ID = txCopy.Hash()
+		txCopy.Inputs[inID].PubKey = nil
+
+		r, s := splitSignature(in.Signature)
+		x, y := splitPubKey(in.PubKey)
+
+		rawPubKey := ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+		if !ecdsa.Verify(&rawPubKey, txCopy.ID, r, s) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// fieldByteLen secp256k1の座標・スカラー成分を固定幅でエンコードするためのバイト長
+const fieldByteLen = 32
+
+/*
+padToFieldByteLen big.IntをfieldByteLen幅のビッグエンディアンバイト列にする
+ big.Int.Bytes()は先頭の0バイトを落とすため、そのままr||sやX||Yを連結すると
+ 成分の境界がずれる。署名・結合のどちらでも必ずこの固定幅を通して連結する
+*/
+func padToFieldByteLen(n *big.Int) []byte {
+	b := n.Bytes()
+	if len(b) >= fieldByteLen {
+		return b
+	}
+	padded := make([]byte, fieldByteLen)
+	copy(padded[fieldByteLen-len(b):], b)
+	return padded
+}
+
+func splitSignature(signature []byte) (r, s *big.Int) {
+	half := len(signature) / 2
+	r = new(big.Int).SetBytes(signature[:half])
+	s = new(big.Int).SetBytes(signature[half:])
+	return r, s
+}
+
+func splitPubKey(pubKey []byte) (x, y *big.Int) {
+	half := len(pubKey) / 2
+	x = new(big.Int).SetBytes(pubKey[:half])
+	y = new(big.Int).SetBytes(pubKey[half:])
+	return x, y
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/soichiro0311/BlockChain/wallet"
+)
+
+// TestSignVerifyRoundTrip はSign/Verifyを多数回繰り返す。r・s・X・Yの各バイト列を
+// 固定幅にパディングせず連結していた場合、短いエンコードになる鍵や署名が
+// 一定の確率で出現するたびにVerifyが不正に失敗する
+func TestSignVerifyRoundTrip(t *testing.T) {
+	for i := 0; i < 200; i++ {
+		from := wallet.New()
+		to := wallet.New()
+
+		prevTx := NewCoinbaseTx(string(from.GetAddress()), 100)
+
+		tx := Transaction{
+			Inputs: []TxInput{
+				{PrevTxID: prevTx.ID, OutIndex: 0, PubKey: from.PublicKey},
+			},
+			Outputs: []TxOutput{
+				NewTxOutput(100, string(to.GetAddress())),
+			},
+		}
+		tx.ID = tx.Hash()
+
+		prevTXs := map[string]Transaction{string(prevTx.ID): prevTx}
+
+		if err := tx.Sign(from.PrivateKey, prevTXs); err != nil {
+			t.Fatalf("iteration %d: Sign returned error: %v", i, err)
+		}
+
+		if !tx.Verify(prevTXs) {
+			t.Fatalf("iteration %d: Verify = false for a legitimately signed transaction", i)
+		}
+	}
+}
+
+// TestVerifyRejectsMismatchedPubKey は、攻撃者が自分の鍵で正しく署名していても、
+// InputのPubKeyが参照先Outputを実際にロックしているPubKeyHashと一致しなければ
+// Verifyがfalseを返すことを確認する。UsesKeyによるこの所有者チェックがなければ、
+// 誰でも他人のUTXOを自分の鍵で使えてしまう
+func TestVerifyRejectsMismatchedPubKey(t *testing.T) {
+	victim := wallet.New()
+	attacker := wallet.New()
+
+	victimsFunding := NewCoinbaseTx(string(victim.GetAddress()), 100)
+
+	tx := Transaction{
+		Inputs: []TxInput{
+			{PrevTxID: victimsFunding.ID, OutIndex: 0, PubKey: attacker.PublicKey},
+		},
+		Outputs: []TxOutput{
+			NewTxOutput(100, string(attacker.GetAddress())),
+		},
+	}
+	tx.ID = tx.Hash()
+
+	prevTXs := map[string]Transaction{string(victimsFunding.ID): victimsFunding}
+
+	if err := tx.Sign(attacker.PrivateKey, prevTXs); err != nil {
+		t.Fatalf("Sign returned error: %v", err)
+	}
+
+	if tx.Verify(prevTXs) {
+		t.Fatal("Verify = true for a transaction spending another wallet's UTXO with a self-consistent but unrelated key")
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+)
+
+/*
+indexedOutput TxOutputを、元のTransaction.Outputsにおけるインデックス付きで保持する
+ このインデックスがそのままTxInput.OutIndexとして使われるため、
+ Reindexでフィルタした後のスライス内の位置と混同してはならない
+*/
+type indexedOutput struct {
+	Index int
+	Out   TxOutput
+}
+
+/*
+UTXOSet 未使用トランザクション出力(UTXO)をトランザクションIDごとにまとめたキャッシュ
+ Chainが伸びるたびにReindexして作り直す
+*/
+type UTXOSet struct {
+	// トランザクションID(16進文字列) -> そのトランザクションの未使用出力(元のOutIndex付き)
+	outputs map[string][]indexedOutput
+}
+
+/*
+Reindex Chain全体を走査して未使用出力だけを集め直す
+*/
+func (u *UTXOSet) Reindex(chain []Block) {
+	u.outputs = make(map[string][]indexedOutput)
+	spent := make(map[string]map[int]bool)
+
+	markSpent := func(txID []byte, outIndex int) {
+		key := hex.EncodeToString(txID)
+		if spent[key] == nil {
+			spent[key] = make(map[int]bool)
+		}
+		spent[key][outIndex] = true
+	}
+
+	isSpent := func(txID []byte, outIndex int) bool {
+		key := hex.EncodeToString(txID)
+		return spent[key] != nil && spent[key][outIndex]
+	}
+
+	// 先に全ブロックのInputsを見て、使用済みの出力を洗い出す
+	for _, block := range chain {
+		for _, tx := range block.Transactions {
+			if tx.IsCoinbase() {
+				continue
+			}
+			for _, in := range tx.Inputs {
+				markSpent(in.PrevTxID, in.OutIndex)
+			}
+		}
+	}
+
+	for _, block := range chain {
+		for _, tx := range block.Transactions {
+			txID := hex.EncodeToString(tx.ID)
+			for outIndex, out := range tx.Outputs {
+				if !isSpent(tx.ID, outIndex) {
+					u.outputs[txID] = append(u.outputs[txID], indexedOutput{Index: outIndex, Out: out})
+				}
+			}
+		}
+	}
+}
+
+/*
+FindSpendableOutputs 指定したPubKeyHashが使える未使用出力を、合計がamount以上になるまで集める
+ :return <int> 集められた合計額
+ :return <map[string][]int> トランザクションID(16進文字列) -> 使う出力インデックスの配列
+*/
+func (u *UTXOSet) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[string][]int) {
+	unspentOutputs := make(map[string][]int)
+	accumulated := 0
+
+	for txID, outs := range u.outputs {
+		for _, indexed := range outs {
+			if indexed.Out.IsLockedWithKey(pubKeyHash) && accumulated < amount {
+				accumulated += indexed.Out.Value
+				unspentOutputs[txID] = append(unspentOutputs[txID], indexed.Index)
+			}
+		}
+		if accumulated >= amount {
+			break
+		}
+	}
+
+	return accumulated, unspentOutputs
+}
+
+/*
+spentInMempool 指定の出力がmempool中の他のトランザクションで既に使われていないかを調べる
+ 二重支払い(同じ出力を使う複数のトランザクションがmempoolに並ぶこと)を拒否するために使う
+*/
+func spentInMempool(mempool []Transaction, prevTxID []byte, outIndex int) bool {
+	for _, tx := range mempool {
+		for _, in := range tx.Inputs {
+			if bytes.Equal(in.PrevTxID, prevTxID) && in.OutIndex == outIndex {
+				return true
+			}
+		}
+	}
+	return false
+}
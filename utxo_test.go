@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/soichiro0311/BlockChain/wallet"
+)
+
+// TestFindSpendableOutputsAfterPartialSpend は、同じトランザクションの2つの出力のうち
+// 片方(index 0)だけが使われた後でも、もう片方(index 1)が正しい元のOutIndexで
+// 返ってくることを確認する。Reindexがフィルタ後のスライス位置を返してしまうと、
+// ここでindex 0が返り、既に使用済みの出力を指してしまう(二重支払いの原因になる)
+func TestFindSpendableOutputsAfterPartialSpend(t *testing.T) {
+	alice := wallet.New()
+	bob := wallet.New()
+	carol := wallet.New()
+
+	fundingTx := NewCoinbaseTx(string(alice.GetAddress()), 100)
+
+	payToBob := Transaction{
+		Inputs: []TxInput{
+			{PrevTxID: fundingTx.ID, OutIndex: 0, PubKey: alice.PublicKey},
+		},
+		Outputs: []TxOutput{
+			NewTxOutput(10, string(bob.GetAddress())),
+			NewTxOutput(90, string(alice.GetAddress())),
+		},
+	}
+	payToBob.ID = payToBob.Hash()
+
+	// bobの出力(index 0)を使い切る。これでpayToBobのoutputsのうちindex 0だけが
+	// 使用済みになり、aliceへのお釣り(index 1)はフィルタ後のスライスでは
+	// 先頭(位置0)にずれる
+	spendBobsOutput := Transaction{
+		Inputs: []TxInput{
+			{PrevTxID: payToBob.ID, OutIndex: 0, PubKey: bob.PublicKey},
+		},
+		Outputs: []TxOutput{
+			NewTxOutput(10, string(carol.GetAddress())),
+		},
+	}
+	spendBobsOutput.ID = spendBobsOutput.Hash()
+
+	chain := []Block{
+		{Index: 1, Transactions: []Transaction{fundingTx}},
+		{Index: 2, Transactions: []Transaction{payToBob}},
+		{Index: 3, Transactions: []Transaction{spendBobsOutput}},
+	}
+
+	var utxoSet UTXOSet
+	utxoSet.Reindex(chain)
+
+	pubKeyHash := wallet.HashPubKey(alice.PublicKey)
+	accumulated, validOutputs := utxoSet.FindSpendableOutputs(pubKeyHash, 90)
+
+	if accumulated != 90 {
+		t.Fatalf("accumulated = %d, want 90", accumulated)
+	}
+
+	txID := hex.EncodeToString(payToBob.ID)
+	indexes, ok := validOutputs[txID]
+	if !ok {
+		t.Fatalf("validOutputs missing entry for payToBob tx %s", txID)
+	}
+	if len(indexes) != 1 || indexes[0] != 1 {
+		t.Fatalf("validOutputs[%s] = %v, want [1]", txID, indexes)
+	}
+}
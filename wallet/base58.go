@@ -0,0 +1,67 @@
+package wallet
+
+import (
+	"bytes"
+	"math/big"
+)
+
+var b58Alphabet = []byte("123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz")
+
+/*
+Base58Encode バイト列をBase58でエンコードする
+ 先頭のゼロバイトは"1"として残し、紛らわしい文字(0, O, I, l)は使わない
+*/
+func Base58Encode(input []byte) []byte {
+	var result []byte
+
+	x := big.NewInt(0).SetBytes(input)
+
+	base := big.NewInt(int64(len(b58Alphabet)))
+	zero := big.NewInt(0)
+	mod := &big.Int{}
+
+	for x.Cmp(zero) != 0 {
+		x.DivMod(x, base, mod)
+		result = append(result, b58Alphabet[mod.Int64()])
+	}
+
+	for _, b := range input {
+		if b != 0x00 {
+			break
+		}
+		result = append(result, b58Alphabet[0])
+	}
+
+	reverse(result)
+
+	return result
+}
+
+/*
+Base58Decode Base58文字列をデコードして元のバイト列に戻す
+*/
+func Base58Decode(input []byte) []byte {
+	result := big.NewInt(0)
+
+	for _, b := range input {
+		charIndex := bytes.IndexByte(b58Alphabet, b)
+		result.Mul(result, big.NewInt(int64(len(b58Alphabet))))
+		result.Add(result, big.NewInt(int64(charIndex)))
+	}
+
+	decoded := result.Bytes()
+	for _, b := range input {
+		if b != b58Alphabet[0] {
+			break
+		}
+		decoded = append([]byte{0x00}, decoded...)
+	}
+
+	return decoded
+}
+
+func reverse(b []byte) {
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+}
@@ -0,0 +1,113 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+
+	"github.com/btcsuite/btcd/btcec"
+	"golang.org/x/crypto/ripemd160"
+)
+
+const version = byte(0x00)
+const addressChecksumLen = 4
+
+/*
+Wallet secp256k1の鍵ペアを保持するウォレット
+*/
+type Wallet struct {
+	PrivateKey ecdsa.PrivateKey
+	PublicKey  []byte
+}
+
+/*
+New secp256k1の鍵ペアを新しく生成してWalletを作る
+*/
+func New() *Wallet {
+	private, public := newKeyPair()
+	return &Wallet{PrivateKey: private, PublicKey: public}
+}
+
+func newKeyPair() (ecdsa.PrivateKey, []byte) {
+	curve := btcec.S256()
+
+	private, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	// X||Yは各32バイトの固定幅で連結する。Signが入力へ書き込む公開鍵と同じ表現にしないと、
+	// 先頭に0バイトを持つ座標でアドレスのPubKeyHashと一致しなくなる
+	public := make([]byte, 64)
+	x := private.PublicKey.X.Bytes()
+	y := private.PublicKey.Y.Bytes()
+	copy(public[32-len(x):32], x)
+	copy(public[64-len(y):], y)
+
+	return *private, public
+}
+
+/*
+GetAddress 公開鍵からBase58Checkアドレスを導出する
+ RIPEMD160(SHA256(pubkey))にバージョンを付け、チェックサムを付与してエンコードする
+*/
+func (w Wallet) GetAddress() []byte {
+	pubKeyHash := HashPubKey(w.PublicKey)
+
+	versionedPayload := append([]byte{version}, pubKeyHash...)
+	checksum := checksum(versionedPayload)
+
+	fullPayload := append(versionedPayload, checksum...)
+	address := Base58Encode(fullPayload)
+
+	return address
+}
+
+/*
+HashPubKey 公開鍵からRIPEMD160(SHA256(pubkey))を計算する
+*/
+func HashPubKey(pubKey []byte) []byte {
+	publicSHA256 := sha256.Sum256(pubKey)
+
+	ripemd160Hasher := ripemd160.New()
+	if _, err := ripemd160Hasher.Write(publicSHA256[:]); err != nil {
+		panic(err)
+	}
+
+	return ripemd160Hasher.Sum(nil)
+}
+
+/*
+ValidateAddress アドレスのチェックサムが正しいか検証する
+*/
+func ValidateAddress(address string) bool {
+	pubKeyHash := Base58Decode([]byte(address))
+	if len(pubKeyHash) < addressChecksumLen+1 {
+		return false
+	}
+
+	actualChecksum := pubKeyHash[len(pubKeyHash)-addressChecksumLen:]
+	version := pubKeyHash[0]
+	payload := pubKeyHash[1 : len(pubKeyHash)-addressChecksumLen]
+
+	targetChecksum := checksum(append([]byte{version}, payload...))
+
+	return bytes.Equal(actualChecksum, targetChecksum)
+}
+
+/*
+PubKeyHashFromAddress アドレスから先頭のバージョンと末尾のチェックサムを除いた
+ PubKeyHashを取り出す
+*/
+func PubKeyHashFromAddress(address string) []byte {
+	decoded := Base58Decode([]byte(address))
+	return decoded[1 : len(decoded)-addressChecksumLen]
+}
+
+func checksum(payload []byte) []byte {
+	firstSHA := sha256.Sum256(payload)
+	secondSHA := sha256.Sum256(firstSHA[:])
+
+	return secondSHA[:addressChecksumLen]
+}
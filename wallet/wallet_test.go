@@ -0,0 +1,30 @@
+package wallet
+
+import "testing"
+
+func TestGetAddressRoundTrip(t *testing.T) {
+	w := New()
+	address := string(w.GetAddress())
+
+	if !ValidateAddress(address) {
+		t.Fatalf("ValidateAddress(%q) = false, want true", address)
+	}
+
+	pubKeyHash := PubKeyHashFromAddress(address)
+	if string(pubKeyHash) != string(HashPubKey(w.PublicKey)) {
+		t.Fatalf("PubKeyHashFromAddress does not match HashPubKey(w.PublicKey)")
+	}
+}
+
+func TestValidateAddressRejectsTamperedChecksum(t *testing.T) {
+	w := New()
+	address := []byte(w.GetAddress())
+
+	decoded := Base58Decode(address)
+	decoded[len(decoded)-1] ^= 0xFF
+	tampered := string(Base58Encode(decoded))
+
+	if ValidateAddress(tampered) {
+		t.Fatalf("ValidateAddress accepted an address with a tampered checksum")
+	}
+}